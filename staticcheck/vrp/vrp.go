@@ -61,6 +61,1085 @@ func (c *PhiConstraint) String() string {
 	return fmt.Sprintf("%s = φ(%s)", c.Y().Name(), strings.Join(names, ", "))
 }
 
+// isSignedInt reports whether typ is a basic type without the unsigned
+// flag; for integer types that means a signed integer type. Non-integer
+// basic types, such as floats, also report true.
+func isSignedInt(typ types.Type) bool {
+	basic, ok := typ.Underlying().(*types.Basic)
+	return ok && (basic.Info()&types.IsUnsigned) == 0
+}
+
+// bitsOf returns the width, in bits, of typ.
+//
+// int, uint, and uintptr are platform-dependent; we assume the now
+// dominant 64-bit word size for them, same as types.StdSizes{WordSize: 8}
+// would. Callers such as zQuo and fitsInBits use the width as the exact
+// point of wraparound, so on 32-bit targets the ranges of these types
+// assume 64-bit overflow behaviour.
+func bitsOf(typ types.Type) int {
+	basic, ok := typ.Underlying().(*types.Basic)
+	if !ok {
+		return 64
+	}
+	switch basic.Kind() {
+	case types.Int8, types.Uint8:
+		return 8
+	case types.Int16, types.Uint16:
+		return 16
+	case types.Int32, types.Uint32:
+		return 32
+	default:
+		return 64
+	}
+}
+
+func minSigned(bits int) Z {
+	n := big.NewInt(1)
+	n.Lsh(n, uint(bits-1))
+	n.Neg(n)
+	return NewZ(n)
+}
+
+func maxSigned(bits int) Z {
+	n := big.NewInt(1)
+	n.Lsh(n, uint(bits-1))
+	n.Sub(n, big.NewInt(1))
+	return NewZ(n)
+}
+
+func maxUnsigned(bits int) Z {
+	n := big.NewInt(1)
+	n.Lsh(n, uint(bits))
+	n.Sub(n, big.NewInt(1))
+	return NewZ(n)
+}
+
+// zFromConst converts an SSA integer constant to a Z, using the
+// constant's declared signedness so that large unsigned values (for
+// example uint64(1)<<63) don't get misread as negative.
+func zFromConst(c *ssa.Const) Z {
+	if isSignedInt(c.Type()) {
+		v, _ := constant.Int64Val(c.Value)
+		return NewZ(big.NewInt(v))
+	}
+	v, _ := constant.Uint64Val(c.Value)
+	return NewZ(new(big.Int).SetUint64(v))
+}
+
+// TypedInterval pairs an Interval with the bit width and signedness of
+// the ssa.Value it describes, so that operations caring about
+// wraparound (narrowing conversions, division) don't have to re-derive
+// this from the SSA type at every step.
+type TypedInterval struct {
+	Interval
+	Bits   int
+	Signed bool
+}
+
+func NewTypedInterval(i Interval, typ types.Type) TypedInterval {
+	return TypedInterval{i, bitsOf(typ), isSignedInt(typ)}
+}
+
+// IntQuoConstraint models Y = A / B for integer division, truncating
+// toward zero as Go's / operator does.
+type IntQuoConstraint struct {
+	aConstraint
+	A ssa.Value
+	B ssa.Value
+}
+
+func NewQuoConstraint(a, b, y ssa.Value) Constraint {
+	return &IntQuoConstraint{aConstraint{y}, a, b}
+}
+
+func (c *IntQuoConstraint) Operands() []ssa.Value { return []ssa.Value{c.A, c.B} }
+
+func (c *IntQuoConstraint) String() string {
+	return fmt.Sprintf("%s = %s / %s", c.Y().Name(), c.A.Name(), c.B.Name())
+}
+
+func (c *IntQuoConstraint) Eval(g *Graph) Range {
+	xi, ok1 := g.Range(c.A).(Interval)
+	yi, ok2 := g.Range(c.B).(Interval)
+	if !ok1 || !ok2 || !xi.IsKnown() || !yi.IsKnown() {
+		return Interval{}
+	}
+	ti := NewTypedInterval(xi, c.Y().Type())
+
+	var out Range
+	for _, div := range splitOutZero(yi, ti.Signed) {
+		out = quoCorners(xi, div, ti.Signed, ti.Bits).Union(out)
+	}
+	if out == nil {
+		// the divisor could only ever have been zero
+		return Interval{}
+	}
+	return out
+}
+
+// splitOutZero removes the zero divisor from yi, splitting it into up to
+// two intervals that straddle it, e.g. [-2, 3] becomes [-2, -1] and
+// [1, 3].
+func splitOutZero(yi Interval, signed bool) []Interval {
+	one := NewZ(big.NewInt(1))
+	if !signed {
+		lower := yi.lower
+		if lower.Sign() <= 0 {
+			lower = one
+		}
+		if lower.Cmp(yi.upper) == 1 {
+			return nil
+		}
+		return []Interval{NewInterval(lower, yi.upper)}
+	}
+
+	negOne := NewZ(big.NewInt(-1))
+	var out []Interval
+	if yi.lower.Sign() < 0 {
+		upper := yi.upper
+		if upper.Sign() >= 0 {
+			upper = negOne
+		}
+		if yi.lower.Cmp(upper) != 1 {
+			out = append(out, NewInterval(yi.lower, upper))
+		}
+	}
+	if yi.upper.Sign() > 0 {
+		lower := yi.lower
+		if lower.Sign() <= 0 {
+			lower = one
+		}
+		if lower.Cmp(yi.upper) != 1 {
+			out = append(out, NewInterval(lower, yi.upper))
+		}
+	}
+	return out
+}
+
+func quoCorners(xi, yi Interval, signed bool, bits int) Interval {
+	corners := [...]Z{
+		zQuo(xi.lower, yi.lower, signed, bits),
+		zQuo(xi.lower, yi.upper, signed, bits),
+		zQuo(xi.upper, yi.lower, signed, bits),
+		zQuo(xi.upper, yi.upper, signed, bits),
+	}
+	lower, upper := corners[0], corners[0]
+	for _, z := range corners[1:] {
+		if z.Cmp(lower) == -1 {
+			lower = z
+		}
+		if z.Cmp(upper) == 1 {
+			upper = z
+		}
+	}
+	return NewInterval(lower, upper)
+}
+
+func zQuo(a, b Z, signed bool, bits int) Z {
+	switch {
+	case a == PInfinity:
+		if b.Sign() < 0 {
+			return NInfinity
+		}
+		return PInfinity
+	case a == NInfinity:
+		if b.Sign() < 0 {
+			return PInfinity
+		}
+		return NInfinity
+	case b == PInfinity || b == NInfinity:
+		// a finite value divided by an unbounded divisor tends to 0
+		return NewZ(&big.Int{})
+	}
+	if signed {
+		min := minSigned(bits)
+		if a.Cmp(min) == 0 && b.Cmp(NewZ(big.NewInt(-1))) == 0 {
+			// MinInt/-1 overflows and wraps back around to MinInt
+			return min
+		}
+	}
+	return NewZ(new(big.Int).Quo(a, b))
+}
+
+// IntRemConstraint models Y = A % B.
+type IntRemConstraint struct {
+	aConstraint
+	A ssa.Value
+	B ssa.Value
+}
+
+func NewRemConstraint(a, b, y ssa.Value) Constraint {
+	return &IntRemConstraint{aConstraint{y}, a, b}
+}
+
+func (c *IntRemConstraint) Operands() []ssa.Value { return []ssa.Value{c.A, c.B} }
+
+func (c *IntRemConstraint) String() string {
+	return fmt.Sprintf("%s = %s %% %s", c.Y().Name(), c.A.Name(), c.B.Name())
+}
+
+func (c *IntRemConstraint) Eval(g *Graph) Range {
+	xi, ok1 := g.Range(c.A).(Interval)
+	yi, ok2 := g.Range(c.B).(Interval)
+	if !ok1 || !ok2 || !xi.IsKnown() || !yi.IsKnown() {
+		return Interval{}
+	}
+	bound := maxAbs(yi)
+	if bound == nil {
+		// the divisor is unbounded, so we can't say anything about the
+		// magnitude of the remainder
+		return InfinityFor(c.Y())
+	}
+	boundMinusOne := NewZ(new(big.Int).Sub(bound, big.NewInt(1)))
+
+	if !isSignedInt(c.Y().Type()) {
+		return NewInterval(NewZ(&big.Int{}), boundMinusOne)
+	}
+
+	// tighten by the sign of the dividend: a non-negative dividend can
+	// only produce a non-negative remainder, and vice versa
+	lower, upper := NewZ(new(big.Int).Neg(boundMinusOne)), boundMinusOne
+	if xi.lower.Sign() >= 0 {
+		lower = NewZ(&big.Int{})
+	}
+	if xi.upper.Sign() <= 0 {
+		upper = NewZ(&big.Int{})
+	}
+	return NewInterval(lower, upper)
+}
+
+// maxAbs returns the larger of the magnitudes of i's bounds, or nil if i
+// isn't bounded on both ends.
+func maxAbs(i Interval) *big.Int {
+	if i.lower == NInfinity || i.upper == PInfinity {
+		return nil
+	}
+	l := new(big.Int).Abs(i.lower)
+	u := new(big.Int).Abs(i.upper)
+	if l.Cmp(u) == 1 {
+		return l
+	}
+	return u
+}
+
+// IntShlConstraint models Y = A << B.
+type IntShlConstraint struct {
+	aConstraint
+	A ssa.Value
+	B ssa.Value
+}
+
+func NewShlConstraint(a, b, y ssa.Value) Constraint {
+	return &IntShlConstraint{aConstraint{y}, a, b}
+}
+
+func (c *IntShlConstraint) Operands() []ssa.Value { return []ssa.Value{c.A, c.B} }
+
+func (c *IntShlConstraint) String() string {
+	return fmt.Sprintf("%s = %s << %s", c.Y().Name(), c.A.Name(), c.B.Name())
+}
+
+func (c *IntShlConstraint) Eval(g *Graph) Range {
+	xi, ok1 := g.Range(c.A).(Interval)
+	yi, ok2 := g.Range(c.B).(Interval)
+	if !ok1 || !ok2 || !xi.IsKnown() || !yi.IsKnown() {
+		return Interval{}
+	}
+	width := NewZ(big.NewInt(int64(bitsOf(c.Y().Type()))))
+	if yi.upper == PInfinity || yi.upper.Cmp(width) != -1 {
+		// shifting by the width of the type or more is bound to overflow
+		return NewInterval(NInfinity, PInfinity)
+	}
+	// a shift count can't be negative without the program already
+	// panicking, so clamp away an unbounded or negative lower bound
+	// before it reaches zShl's Lsh call
+	yLower := nonNegativeShiftCount(yi.lower)
+	// monotone in the shift count, but not in A's sign, so take the
+	// extremes of all four corners like IntShrConstraint does
+	corners := [...]Z{
+		zShl(xi.lower, yLower),
+		zShl(xi.lower, yi.upper),
+		zShl(xi.upper, yLower),
+		zShl(xi.upper, yi.upper),
+	}
+	lower, upper := corners[0], corners[0]
+	for _, z := range corners[1:] {
+		if z.Cmp(lower) == -1 {
+			lower = z
+		}
+		if z.Cmp(upper) == 1 {
+			upper = z
+		}
+	}
+	return NewInterval(lower, upper)
+}
+
+func zShl(a, b Z) Z {
+	if a == NInfinity || a == PInfinity {
+		return a
+	}
+	return NewZ(new(big.Int).Lsh(a, uint(b.Int64())))
+}
+
+// nonNegativeShiftCount clamps a shift-count bound to a concrete,
+// non-negative value: a negative or unbounded-below count can't occur at
+// runtime without the shift already panicking, but VRP's interval
+// arithmetic can still propagate one this far, e.g. `if i < 4 { y = x <<
+// i }` gives i the range [-∞, 3].
+func nonNegativeShiftCount(b Z) Z {
+	if b == NInfinity || b.Sign() < 0 {
+		return NewZ(&big.Int{})
+	}
+	return b
+}
+
+// IntShrConstraint models Y = A >> B, an arithmetic right shift.
+type IntShrConstraint struct {
+	aConstraint
+	A ssa.Value
+	B ssa.Value
+}
+
+func NewShrConstraint(a, b, y ssa.Value) Constraint {
+	return &IntShrConstraint{aConstraint{y}, a, b}
+}
+
+func (c *IntShrConstraint) Operands() []ssa.Value { return []ssa.Value{c.A, c.B} }
+
+func (c *IntShrConstraint) String() string {
+	return fmt.Sprintf("%s = %s >> %s", c.Y().Name(), c.A.Name(), c.B.Name())
+}
+
+func (c *IntShrConstraint) Eval(g *Graph) Range {
+	xi, ok1 := g.Range(c.A).(Interval)
+	yi, ok2 := g.Range(c.B).(Interval)
+	if !ok1 || !ok2 || !xi.IsKnown() || !yi.IsKnown() {
+		return Interval{}
+	}
+	// a shift count can't be negative without the program already
+	// panicking, so clamp away an unbounded or negative lower bound
+	// before it reaches zShr's Rsh call
+	yLower := nonNegativeShiftCount(yi.lower)
+	// monotone in both operands, but the direction depends on the sign
+	// of A, so just take the extremes of all four corners
+	corners := [...]Z{
+		zShr(xi.lower, yLower),
+		zShr(xi.lower, yi.upper),
+		zShr(xi.upper, yLower),
+		zShr(xi.upper, yi.upper),
+	}
+	lower, upper := corners[0], corners[0]
+	for _, z := range corners[1:] {
+		if z.Cmp(lower) == -1 {
+			lower = z
+		}
+		if z.Cmp(upper) == 1 {
+			upper = z
+		}
+	}
+	return NewInterval(lower, upper)
+}
+
+func zShr(a, b Z) Z {
+	if a == NInfinity || a == PInfinity {
+		return a
+	}
+	if b == PInfinity {
+		// shifted all the way out, only the sign bit survives
+		if a.Sign() < 0 {
+			return NewZ(big.NewInt(-1))
+		}
+		return NewZ(&big.Int{})
+	}
+	return NewZ(new(big.Int).Rsh(a, uint(b.Int64())))
+}
+
+// IntConversionConstraint models Y = T(X), a conversion to the integer
+// type T of Y.
+type IntConversionConstraint struct {
+	aConstraint
+	X ssa.Value
+}
+
+func (c *IntConversionConstraint) Operands() []ssa.Value { return []ssa.Value{c.X} }
+
+func (c *IntConversionConstraint) String() string {
+	return fmt.Sprintf("%s = %s(%s)", c.Y().Name(), c.Y().Type(), c.X.Name())
+}
+
+func (c *IntConversionConstraint) Eval(g *Graph) Range {
+	xi, ok := g.Range(c.X).(Interval)
+	if !ok || !xi.IsKnown() {
+		return Interval{}
+	}
+	if xi.lower == NInfinity || xi.upper == PInfinity {
+		return InfinityFor(c.Y())
+	}
+	ti := NewTypedInterval(xi, c.Y().Type())
+	if fitsInBits(xi.lower, ti.Bits, ti.Signed) && fitsInBits(xi.upper, ti.Bits, ti.Signed) {
+		// the whole source range is representable in the target type, so
+		// the conversion is a no-op for range-tracking purposes
+		return xi
+	}
+	// a narrowing conversion that doesn't fit can wrap around, so without
+	// knowing the concrete runtime value we can't bound the result any
+	// tighter than the target type's own range
+	if ti.Signed {
+		return NewInterval(minSigned(ti.Bits), maxSigned(ti.Bits))
+	}
+	return NewInterval(NewZ(&big.Int{}), maxUnsigned(ti.Bits))
+}
+
+func fitsInBits(z Z, bits int, signed bool) bool {
+	if signed {
+		return z.Cmp(minSigned(bits)) != -1 && z.Cmp(maxSigned(bits)) != 1
+	}
+	return z.Sign() != -1 && z.Cmp(maxUnsigned(bits)) != 1
+}
+
+// LenConstraint models Y = len(X) for a slice, string, or array value.
+type LenConstraint struct {
+	aConstraint
+	X ssa.Value
+}
+
+func NewLenConstraint(x, y ssa.Value) Constraint {
+	return &LenConstraint{aConstraint{y}, x}
+}
+
+func (c *LenConstraint) Operands() []ssa.Value { return lenOperands(c.X) }
+
+func (c *LenConstraint) String() string {
+	return fmt.Sprintf("%s = len(%s)", c.Y().Name(), c.X.Name())
+}
+
+func (c *LenConstraint) Eval(g *Graph) Range {
+	return aggregateLen(g, c.X, false)
+}
+
+// CapConstraint models Y = cap(X) for a slice or array value.
+type CapConstraint struct {
+	aConstraint
+	X ssa.Value
+}
+
+func NewCapConstraint(x, y ssa.Value) Constraint {
+	return &CapConstraint{aConstraint{y}, x}
+}
+
+func (c *CapConstraint) Operands() []ssa.Value { return lenOperands(c.X) }
+
+func (c *CapConstraint) String() string {
+	return fmt.Sprintf("%s = cap(%s)", c.Y().Name(), c.X.Name())
+}
+
+func (c *CapConstraint) Eval(g *Graph) Range {
+	return aggregateLen(g, c.X, true)
+}
+
+// lenOperands returns the operands that feed into aggregateLen(x, ...),
+// so that the graph builder can wire up the edges the solver needs to
+// have them evaluated first.
+//
+// XXX this only looks one level deep; a len() of a re-slice of a
+// re-slice won't have all of its transitive operands wired up, and will
+// fall back to the conservative [0, ∞) range.
+func lenOperands(x ssa.Value) []ssa.Value {
+	switch x := x.(type) {
+	case *ssa.MakeSlice:
+		return []ssa.Value{x.Len, x.Cap}
+	case *ssa.Slice:
+		var ops []ssa.Value
+		if x.Low != nil {
+			ops = append(ops, x.Low)
+		}
+		if x.High != nil {
+			ops = append(ops, x.High)
+		}
+		if x.Max != nil {
+			ops = append(ops, x.Max)
+		}
+		return ops
+	default:
+		return nil
+	}
+}
+
+// aggregateLen computes the range of len(x), or of cap(x) when wantCap
+// is set, for a slice-, string-, or array-typed value, following
+// through the instructions known to establish it.
+func aggregateLen(g *Graph, x ssa.Value, wantCap bool) Range {
+	zero := NewZ(&big.Int{})
+	if n, ok := arrayLen(x.Type()); ok {
+		z := NewZ(big.NewInt(n))
+		return NewInterval(z, z)
+	}
+	switch x := x.(type) {
+	case *ssa.MakeSlice:
+		if wantCap {
+			return g.Range(x.Cap)
+		}
+		return g.Range(x.Len)
+	case *ssa.Slice:
+		var hi Range
+		switch {
+		case wantCap && x.Max != nil:
+			hi = g.Range(x.Max)
+		case !wantCap && x.High != nil:
+			hi = g.Range(x.High)
+		default:
+			hi = aggregateLen(g, x.X, wantCap)
+		}
+		lo := Range(NewInterval(zero, zero))
+		if x.Low != nil {
+			lo = g.Range(x.Low)
+		}
+		return subtractLens(hi, lo)
+	}
+	return NewInterval(zero, PInfinity)
+}
+
+func arrayLen(typ types.Type) (int64, bool) {
+	switch t := typ.Underlying().(type) {
+	case *types.Array:
+		return t.Len(), true
+	case *types.Pointer:
+		if a, ok := t.Elem().Underlying().(*types.Array); ok {
+			return a.Len(), true
+		}
+	}
+	return 0, false
+}
+
+// subtractLens computes hi - lo, clamped to be non-negative, for use in
+// re-slicing: the result of a[lo:hi] has length/cap hi-lo.
+func subtractLens(hi, lo Range) Range {
+	zero := NewZ(&big.Int{})
+	hiI, ok1 := hi.(Interval)
+	loI, ok2 := lo.(Interval)
+	if !ok1 || !ok2 || !hiI.IsKnown() || !loI.IsKnown() {
+		return NewInterval(zero, PInfinity)
+	}
+	lower := zSub(hiI.lower, loI.upper)
+	upper := zSub(hiI.upper, loI.lower)
+	if lower != NInfinity && lower.Cmp(zero) == -1 {
+		lower = zero
+	}
+	return NewInterval(lower, upper)
+}
+
+func zSub(a, b Z) Z {
+	switch {
+	case a == PInfinity || b == NInfinity:
+		return PInfinity
+	case a == NInfinity || b == PInfinity:
+		return NInfinity
+	default:
+		return NewZ(new(big.Int).Sub(a, b))
+	}
+}
+
+// BoundsVerdict is the result of trying to prove an indexing operation
+// safe, analogous to what the compiler's prove pass does when deciding
+// whether a bounds check can be eliminated.
+type BoundsVerdict int
+
+const (
+	BoundsUnknown BoundsVerdict = iota
+	BoundsSafe
+	BoundsUnsafe
+)
+
+func (v BoundsVerdict) String() string {
+	switch v {
+	case BoundsSafe:
+		return "safe"
+	case BoundsUnsafe:
+		return "unsafe"
+	default:
+		return "unknown"
+	}
+}
+
+// ProveBounds uses g's solved ranges to classify every *ssa.Index,
+// *ssa.IndexAddr, *ssa.Slice, and string *ssa.Lookup in fn as a proven-safe,
+// proven-unsafe (always panics), or unknown access. Callers such as a
+// staticcheck checker can use this to flag dead panics and bounds
+// checks the compiler can't eliminate on its own.
+func ProveBounds(g *Graph, fn *ssa.Function) map[ssa.Instruction]BoundsVerdict {
+	verdicts := map[ssa.Instruction]BoundsVerdict{}
+	for _, b := range fn.Blocks {
+		for _, ins := range b.Instrs {
+			switch ins := ins.(type) {
+			case *ssa.Index:
+				verdicts[ins] = proveIndex(g, ins.X, ins.Index)
+			case *ssa.IndexAddr:
+				verdicts[ins] = proveIndex(g, ins.X, ins.Index)
+			case *ssa.Lookup:
+				// map lookups never panic; only string indexing is
+				// bounds-checked
+				if _, ok := ins.X.Type().Underlying().(*types.Basic); ok {
+					verdicts[ins] = proveIndex(g, ins.X, ins.Index)
+				}
+			case *ssa.Slice:
+				verdicts[ins] = proveSlice(g, ins)
+			}
+		}
+	}
+	return verdicts
+}
+
+// proveIndex decides whether 0 <= index < len(x) is proven or disproven
+// by the solved ranges.
+func proveIndex(g *Graph, x, index ssa.Value) BoundsVerdict {
+	zero := NewZ(&big.Int{})
+	ii, ok := g.Range(index).(Interval)
+	if !ok || !ii.IsKnown() {
+		return BoundsUnknown
+	}
+	li, ok := aggregateLen(g, x, false).(Interval)
+	if !ok || !li.IsKnown() {
+		return BoundsUnknown
+	}
+	if ii.upper.Sign() == -1 {
+		return BoundsUnsafe
+	}
+	if li.upper != PInfinity && ii.lower.Cmp(li.upper) != -1 {
+		return BoundsUnsafe
+	}
+	if ii.lower.Cmp(zero) != -1 && li.lower != NInfinity && ii.upper.Cmp(li.lower) == -1 {
+		return BoundsSafe
+	}
+	return BoundsUnknown
+}
+
+// proveSlice decides whether 0 <= low <= high <= cap(x) is proven or
+// disproven by the solved ranges.
+func proveSlice(g *Graph, ins *ssa.Slice) BoundsVerdict {
+	zero := NewZ(&big.Int{})
+	capI, ok := aggregateLen(g, ins.X, true).(Interval)
+	if !ok || !capI.IsKnown() {
+		return BoundsUnknown
+	}
+	lo := NewInterval(zero, zero)
+	if ins.Low != nil {
+		l, ok := g.Range(ins.Low).(Interval)
+		if !ok || !l.IsKnown() {
+			return BoundsUnknown
+		}
+		lo = l
+	}
+	var hi Interval
+	if ins.High != nil {
+		h, ok := g.Range(ins.High).(Interval)
+		if !ok || !h.IsKnown() {
+			return BoundsUnknown
+		}
+		hi = h
+	} else {
+		h, ok := aggregateLen(g, ins.X, false).(Interval)
+		if !ok || !h.IsKnown() {
+			return BoundsUnknown
+		}
+		hi = h
+	}
+
+	if lo.upper.Sign() == -1 || lo.lower.Cmp(hi.upper) == 1 {
+		return BoundsUnsafe
+	}
+	if capI.upper != PInfinity && hi.lower.Cmp(capI.upper) == 1 {
+		return BoundsUnsafe
+	}
+	if lo.lower.Cmp(zero) != -1 && lo.upper.Cmp(hi.lower) != 1 &&
+		capI.lower != NInfinity && hi.upper.Cmp(capI.lower) != 1 {
+		return BoundsSafe
+	}
+	return BoundsUnknown
+}
+
+// MultiInterval is a Range representing a union of disjoint, sorted
+// Intervals. It lets VRP track punctured ranges, such as the result of
+// `x != 5`, without collapsing them back down to a single enclosing
+// Interval.
+type MultiInterval struct {
+	parts []Interval
+}
+
+// NewMultiInterval builds a MultiInterval out of parts, merging any
+// overlapping or adjacent components.
+func NewMultiInterval(parts ...Interval) Range {
+	parts = normalizeIntervals(parts)
+	switch len(parts) {
+	case 0:
+		return Interval{}
+	case 1:
+		return parts[0]
+	default:
+		return MultiInterval{parts}
+	}
+}
+
+func (m MultiInterval) IsKnown() bool {
+	return len(m.parts) > 0
+}
+
+func (m MultiInterval) Union(other Range) Range {
+	switch other := other.(type) {
+	case nil:
+		return m
+	case Interval:
+		return NewMultiInterval(append(append([]Interval{}, m.parts...), other)...)
+	case MultiInterval:
+		return NewMultiInterval(append(append([]Interval{}, m.parts...), other.parts...)...)
+	default:
+		return m
+	}
+}
+
+// Intersect computes the intersection of m with i.
+func (m MultiInterval) Intersect(i Interval) Range {
+	var out []Interval
+	for _, p := range m.parts {
+		lower, upper := Max(p.lower, i.lower), Min(p.upper, i.upper)
+		if lower.Cmp(upper) != 1 {
+			out = append(out, NewInterval(lower, upper))
+		}
+	}
+	return NewMultiInterval(out...)
+}
+
+func (m MultiInterval) String() string {
+	parts := make([]string, len(m.parts))
+	for i, p := range m.parts {
+		parts[i] = fmt.Sprintf("[%s, %s]", p.lower, p.upper)
+	}
+	return strings.Join(parts, " ∪ ")
+}
+
+// enclosing collapses r down to the smallest single Interval containing
+// every point in it. The widening/narrowing solver only reasons about a
+// single pair of monotonically growing/shrinking bounds, so a punctured
+// MultiInterval (e.g. the result of `x != 5`) has to give up its hole
+// before it can flow through that machinery.
+func enclosing(r Range) Interval {
+	switch r := r.(type) {
+	case Interval:
+		return r
+	case MultiInterval:
+		if len(r.parts) == 0 {
+			return Interval{}
+		}
+		return NewInterval(r.parts[0].lower, r.parts[len(r.parts)-1].upper)
+	default:
+		return Interval{}
+	}
+}
+
+// normalizeIntervals sorts parts by lower bound and merges components
+// that overlap or are adjacent (no integer lies strictly between them),
+// producing a minimal sorted set of disjoint intervals.
+func normalizeIntervals(parts []Interval) []Interval {
+	var known []Interval
+	for _, p := range parts {
+		if p.IsKnown() {
+			known = append(known, p)
+		}
+	}
+	sort.Slice(known, func(i, j int) bool {
+		return known[i].lower.Cmp(known[j].lower) == -1
+	})
+	var out []Interval
+	for _, p := range known {
+		if len(out) > 0 && adjacentOrOverlapping(out[len(out)-1], p) {
+			last := out[len(out)-1]
+			if p.upper.Cmp(last.upper) == 1 {
+				out[len(out)-1] = NewInterval(last.lower, p.upper)
+			}
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func adjacentOrOverlapping(a, b Interval) bool {
+	if a.upper == PInfinity || b.lower == NInfinity {
+		return true
+	}
+	// no gap means there's no integer strictly between a and b
+	gap := new(big.Int).Sub(b.lower, a.upper)
+	return gap.Cmp(big.NewInt(1)) != 1
+}
+
+// puncture returns the range of all integers but point, i.e. (-∞, point)
+// ∪ (point, ∞), modelling the result of `x != point`.
+func puncture(point Z) Range {
+	if point == NInfinity || point == PInfinity {
+		return Interval{}
+	}
+	one := big.NewInt(1)
+	lo := NewInterval(NInfinity, NewZ(new(big.Int).Sub(point, one)))
+	hi := NewInterval(NewZ(new(big.Int).Add(point, one)), PInfinity)
+	return NewMultiInterval(lo, hi)
+}
+
+// intersectRange intersects two Ranges that are each either an Interval
+// or a MultiInterval.
+func intersectRange(a, b Range) Range {
+	switch a := a.(type) {
+	case Interval:
+		switch b := b.(type) {
+		case Interval:
+			return intersectIntervals(a, b)
+		case MultiInterval:
+			return b.Intersect(a)
+		}
+	case MultiInterval:
+		switch b := b.(type) {
+		case Interval:
+			return a.Intersect(b)
+		case MultiInterval:
+			var out Range = Interval{}
+			for _, p := range b.parts {
+				out = a.Intersect(p).Union(out)
+			}
+			return out
+		}
+	}
+	return Interval{}
+}
+
+func intersectIntervals(a, b Interval) Interval {
+	if !a.IsKnown() || !b.IsKnown() {
+		return Interval{}
+	}
+	lower, upper := Max(a.lower, b.lower), Min(a.upper, b.upper)
+	if lower.Cmp(upper) == 1 {
+		return Interval{}
+	}
+	return NewInterval(lower, upper)
+}
+
+// constFact turns a comparison against a known constant v into the Range
+// it implies, or nil if op isn't a comparison. NEQ yields a punctured
+// range rather than a single bounded Interval.
+func constFact(op token.Token, v Z) Range {
+	switch op {
+	case token.EQL:
+		return NewInterval(v, v)
+	case token.GTR, token.GEQ:
+		off := int64(0)
+		if op == token.GTR {
+			off = 1
+		}
+		return NewInterval(NewZ(new(big.Int).Add(v, big.NewInt(off))), PInfinity)
+	case token.LSS, token.LEQ:
+		off := int64(0)
+		if op == token.LSS {
+			off = -1
+		}
+		return NewInterval(NInfinity, NewZ(new(big.Int).Add(v, big.NewInt(off))))
+	case token.NEQ:
+		return puncture(v)
+	default:
+		return nil
+	}
+}
+
+// IntersectionConstraint models Y = X, narrowed to I by a dominating
+// conditional branch whose bound is known at build time (i.e. X was
+// compared against a constant).
+type IntersectionConstraint struct {
+	aConstraint
+	X ssa.Value
+	I Range
+}
+
+func (c *IntersectionConstraint) Operands() []ssa.Value { return []ssa.Value{c.X} }
+
+func (c *IntersectionConstraint) String() string {
+	return fmt.Sprintf("%s = %s ∩ %s", c.Y().Name(), c.X.Name(), c.I)
+}
+
+func (c *IntersectionConstraint) Eval(g *Graph) Range {
+	return intersectRange(g.Range(c.X), c.I)
+}
+
+// IntervalConstraint models Y being unconditionally known to lie in I,
+// such as the value of an *ssa.Const.
+type IntervalConstraint struct {
+	aConstraint
+	I Range
+}
+
+func (c *IntervalConstraint) Operands() []ssa.Value { return nil }
+
+func (c *IntervalConstraint) String() string {
+	return fmt.Sprintf("%s = %s", c.Y().Name(), c.I)
+}
+
+func (c *IntervalConstraint) Eval(g *Graph) Range {
+	return c.I
+}
+
+// boundFact is one piece of evidence bounding a value: ranges[ref],
+// offset by offset.
+type boundFact struct {
+	ref    ssa.Value
+	offset Z
+}
+
+// FutureIntersectionConstraint models Y = X narrowed by one or more
+// dominating conditional branches that compare X against other SSA
+// values (rather than constants), whose own ranges may not be known
+// until later in the solve. Every lower bound is ANDed together by
+// taking their maximum, every upper bound by taking their minimum, and
+// every puncture (from a `!=` comparison) excludes the other operand's
+// value if and once it resolves to a single point.
+type FutureIntersectionConstraint struct {
+	aConstraint
+	X         ssa.Value
+	lowers    []boundFact
+	uppers    []boundFact
+	punctures []boundFact
+	extra     Range // facts already known when the constraint was built, e.g. from a dominating comparison against a constant
+	ranges    map[ssa.Value]Range
+	resolved  bool
+	I         Range
+}
+
+func (c *FutureIntersectionConstraint) Operands() []ssa.Value { return []ssa.Value{c.X} }
+
+func (c *FutureIntersectionConstraint) Futures() []ssa.Value {
+	var out []ssa.Value
+	for _, f := range c.lowers {
+		out = append(out, f.ref)
+	}
+	for _, f := range c.uppers {
+		out = append(out, f.ref)
+	}
+	for _, f := range c.punctures {
+		out = append(out, f.ref)
+	}
+	return out
+}
+
+func (c *FutureIntersectionConstraint) String() string {
+	return fmt.Sprintf("%s = %s ∩ future", c.Y().Name(), c.X.Name())
+}
+
+func (c *FutureIntersectionConstraint) resolveLower(f boundFact) Z {
+	ri, ok := c.ranges[f.ref].(Interval)
+	if !ok || !ri.IsKnown() {
+		return NInfinity
+	}
+	return zAddOffset(ri.lower, f.offset)
+}
+
+func (c *FutureIntersectionConstraint) resolveUpper(f boundFact) Z {
+	ri, ok := c.ranges[f.ref].(Interval)
+	if !ok || !ri.IsKnown() {
+		return PInfinity
+	}
+	return zAddOffset(ri.upper, f.offset)
+}
+
+func zAddOffset(z, off Z) Z {
+	switch {
+	case z == NInfinity || z == PInfinity:
+		return z
+	case off == NInfinity || off == PInfinity:
+		return off
+	default:
+		return NewZ(new(big.Int).Add(z, off))
+	}
+}
+
+func (c *FutureIntersectionConstraint) bound() Range {
+	lower, upper := NInfinity, PInfinity
+	for _, f := range c.lowers {
+		lower = Max(lower, c.resolveLower(f))
+	}
+	for _, f := range c.uppers {
+		upper = Min(upper, c.resolveUpper(f))
+	}
+	var out Range = NewInterval(lower, upper)
+	for _, f := range c.punctures {
+		oi, ok := c.ranges[f.ref].(Interval)
+		if !ok || !oi.IsKnown() || oi.lower.Cmp(oi.upper) != 0 {
+			continue
+		}
+		out = intersectRange(out, puncture(oi.lower))
+	}
+	if c.extra != nil {
+		out = intersectRange(out, c.extra)
+	}
+	return out
+}
+
+func (c *FutureIntersectionConstraint) Resolve() {
+	c.I = c.bound()
+	c.resolved = true
+}
+
+func (c *FutureIntersectionConstraint) Eval(g *Graph) Range {
+	if !c.resolved {
+		c.Resolve()
+	}
+	return intersectRange(g.Range(c.X), c.I)
+}
+
+// condEdge is a conditional branch dominating some block, together with
+// which edge (true or false) was taken to reach it.
+type condEdge struct {
+	cond   ssa.Value
+	branch bool
+}
+
+// sigmaConds walks back from sig's block through its chain of single
+// predecessors, collecting the *ssa.If that ends each of them; the walk
+// stops at the first predecessor that doesn't end in an *ssa.If. Chained
+// comparisons such as `x > 0 && x < 10` lower to separate Ifs in
+// separate blocks, so a single sigma can be constrained by more than
+// one of them.
+func sigmaConds(sig *ssa.Sigma) []condEdge {
+	var out []condEdge
+	block := sig.Block()
+	for first := true; first || len(block.Preds) == 1; first = false {
+		if len(block.Preds) == 0 {
+			break
+		}
+		pred := block.Preds[0]
+		instrs := pred.Instrs
+		if len(instrs) == 0 {
+			break
+		}
+		ifInstr, ok := instrs[len(instrs)-1].(*ssa.If)
+		if !ok {
+			break
+		}
+		out = append(out, condEdge{ifInstr.Cond, ifInstr.Block().Succs[0] == block})
+		block = pred
+	}
+	return out
+}
+
+// resolveCond unwraps leading `!` (*ssa.UnOp with token.NOT) from cond,
+// flipping branch each time, until it finds the underlying *ssa.BinOp or
+// gives up.
+func resolveCond(cond ssa.Value, branch bool) (*ssa.BinOp, bool, bool) {
+	for {
+		u, ok := cond.(*ssa.UnOp)
+		if !ok || u.Op != token.NOT {
+			break
+		}
+		cond = u.X
+		branch = !branch
+	}
+	b, ok := cond.(*ssa.BinOp)
+	return b, branch, ok
+}
+
 func isSupportedType(typ types.Type) bool {
 	switch typ := typ.Underlying().(type) {
 	case *types.Basic:
@@ -107,28 +1186,26 @@ func BuildGraph(f *ssa.Function) *Graph {
 						token.ADD: NewAddConstraint,
 						token.SUB: NewSubConstraint,
 						token.MUL: NewMulConstraint,
-						// XXX support QUO, REM, SHL, SHR
+						token.QUO: NewQuoConstraint,
+						token.REM: NewRemConstraint,
+						token.SHL: NewShlConstraint,
+						token.SHR: NewShrConstraint,
 					}
 					fn, ok := fns[ins.Op]
 					if ok {
 						cs = append(cs, fn(*ops[0], *ops[1], ins))
 					}
-					switch ins.Op {
-					case token.REM:
-						// XXX
-						continue
-						// XXX deal with sign/bits
-						v, _ := constant.Int64Val((*ops[1]).(*ssa.Const).Value)
-						v--
-						i := NewInterval(NewZ(&big.Int{}), NewZ(big.NewInt(v)))
-						c := &IntervalConstraint{
-							aConstraint: aConstraint{
-								y: ins,
-							},
-							I: i,
-						}
-						cs = append(cs, c)
-					}
+				}
+			case *ssa.Call:
+				blt, ok := ins.Common().Value.(*ssa.Builtin)
+				if !ok || len(ins.Common().Args) != 1 {
+					continue
+				}
+				switch blt.Name() {
+				case "len":
+					cs = append(cs, NewLenConstraint(ins.Common().Args[0], ins))
+				case "cap":
+					cs = append(cs, NewCapConstraint(ins.Common().Args[0], ins))
 				}
 			case *ssa.Slice:
 				_, ok := ins.X.Type().Underlying().(*types.Basic)
@@ -157,13 +1234,12 @@ func BuildGraph(f *ssa.Function) *Graph {
 						switch typ := op.Type().Underlying().(type) {
 						case *types.Basic:
 							if (typ.Info() & types.IsInteger) != 0 {
-								// XXX signs/bits
-								val, _ := constant.Int64Val(op.Value)
+								val := zFromConst(op)
 								c := &IntervalConstraint{
 									aConstraint: aConstraint{
 										y: op,
 									},
-									I: NewInterval(NewZ(big.NewInt(val)), NewZ(big.NewInt(val))),
+									I: NewInterval(val, val),
 								}
 								cs = append(cs, c)
 							}
@@ -178,119 +1254,91 @@ func BuildGraph(f *ssa.Function) *Graph {
 				}
 				cs = append(cs, c)
 			case *ssa.Sigma:
-				pred := ins.Block().Preds[0]
-				instrs := pred.Instrs
-				cond, ok := instrs[len(instrs)-1].(*ssa.If).Cond.(*ssa.BinOp)
-				ops := cond.Operands(nil)
-				if !ok {
+				if !isSupportedType(ins.Type()) {
 					continue
 				}
-				switch typ := ins.Type().Underlying().(type) {
-				case *types.Basic:
-					if (typ.Info() & types.IsInteger) == 0 {
+
+				var constI Range
+				var fic *FutureIntersectionConstraint
+				for _, edge := range sigmaConds(ins) {
+					cond, branch, ok := resolveCond(edge.cond, edge.branch)
+					if !ok {
+						// not a single comparison we understand (e.g. a
+						// function call, or a boolean combination we
+						// haven't unwrapped); skip this branch but keep
+						// walking further up the dominator chain
 						continue
 					}
+					ops := cond.Operands(nil)
+					var other ssa.Value
+					op := cond.Op
+					switch {
+					case *ops[0] == ins.X:
+						other = *ops[1]
+					case *ops[1] == ins.X:
+						other = *ops[0]
+						op = invertToken(op)
+					default:
+						// this branch doesn't constrain ins.X at all
+						continue
+					}
+					if !branch {
+						op = invertToken(op)
+					}
 
-					_, ok1 := (*ops[0]).(*ssa.Const)
-					_, ok2 := (*ops[1]).(*ssa.Const)
-					var logic func(op token.Token)
-					if !ok1 && !ok2 {
-						logic = func(op token.Token) {
-							c := &FutureIntersectionConstraint{
-								aConstraint: aConstraint{
-									y: ins,
-								},
-								ranges:      g.ranges,
-								lowerOffset: NewZ(&big.Int{}),
-								upperOffset: NewZ(&big.Int{}),
-							}
-							var other ssa.Value
-							if (*ops[0]) == ins.X {
-								c.X = *ops[0]
-								other = *ops[1]
-							} else {
-								c.X = *ops[1]
-								other = *ops[0]
-								op = invertToken(op)
-							}
-
-							switch op {
-							case token.EQL:
-								c.lower = other
-								c.upper = other
-							case token.GTR, token.GEQ:
-								off := int64(0)
-								if cond.Op == token.GTR {
-									off = 1
-								}
-								c.lower = other
-								c.lowerOffset = NewZ(big.NewInt(off))
-								c.upper = nil
-								c.upperOffset = PInfinity
-							case token.LSS, token.LEQ:
-								off := int64(0)
-								if cond.Op == token.LSS {
-									off = -1
-								}
-								c.lower = nil
-								c.lowerOffset = NInfinity
-								c.upper = other
-								c.upperOffset = NewZ(big.NewInt(off))
-							default:
-								return
-							}
-							cs = append(cs, c)
+					if k, ok := other.(*ssa.Const); ok {
+						v := zFromConst(k)
+						fact := constFact(op, v)
+						if fact == nil {
+							continue
 						}
-					} else {
-						logic = func(op token.Token) {
-							k, ok := (*ops[1]).(*ssa.Const)
-							// XXX investigate in what cases this wouldn't be a Const
-							if !ok {
-								return
-							}
-							// XXX signs, bits
-							v, _ := constant.Int64Val(k.Value)
-							c := &IntersectionConstraint{
-								aConstraint: aConstraint{
-									y: ins,
-								},
-								X: *ops[0],
-							}
-							switch op {
-							case token.EQL:
-								c.I = NewInterval(NewZ(big.NewInt(v)), NewZ(big.NewInt(v)))
-							case token.GTR, token.GEQ:
-								off := int64(0)
-								if cond.Op == token.GTR {
-									off = 1
-								}
-								c.I = NewInterval(
-									NewZ(big.NewInt(v+off)),
-									PInfinity,
-								)
-							case token.LSS, token.LEQ:
-								off := int64(0)
-								if cond.Op == token.LSS {
-									off = -1
-								}
-								c.I = NewInterval(
-									NInfinity,
-									NewZ(big.NewInt(v+off)),
-								)
-							default:
-								return
-							}
-							cs = append(cs, c)
+						if constI == nil {
+							constI = fact
+						} else {
+							constI = intersectRange(constI, fact)
 						}
+						continue
 					}
 
-					op := cond.Op
-					if !ins.Branch {
-						op = (invertToken(op))
+					if fic == nil {
+						fic = &FutureIntersectionConstraint{
+							aConstraint: aConstraint{y: ins},
+							X:           ins.X,
+							ranges:      g.ranges,
+						}
+					}
+					zero := NewZ(&big.Int{})
+					switch op {
+					case token.EQL:
+						fic.lowers = append(fic.lowers, boundFact{other, zero})
+						fic.uppers = append(fic.uppers, boundFact{other, zero})
+					case token.NEQ:
+						fic.punctures = append(fic.punctures, boundFact{other, zero})
+					case token.GTR, token.GEQ:
+						off := int64(0)
+						if op == token.GTR {
+							off = 1
+						}
+						fic.lowers = append(fic.lowers, boundFact{other, NewZ(big.NewInt(off))})
+					case token.LSS, token.LEQ:
+						off := int64(0)
+						if op == token.LSS {
+							off = -1
+						}
+						fic.uppers = append(fic.uppers, boundFact{other, NewZ(big.NewInt(off))})
 					}
-					logic(op)
-				default:
-					//log.Printf("unsupported sigma type %T", typ) // XXX
+				}
+
+				switch {
+				case fic != nil:
+					fic.extra = constI
+					cs = append(cs, fic)
+				case constI != nil:
+					cs = append(cs, &IntersectionConstraint{
+						aConstraint: aConstraint{y: ins},
+						X:           ins.X,
+						I:           constI,
+					})
 				}
 			}
 		}
@@ -321,8 +1369,8 @@ func (g *Graph) Solve() {
 			switch typ := v.Type().Underlying().(type) {
 			case *types.Basic:
 				if (typ.Info() & types.IsInteger) != 0 {
-					c, _ := constant.Int64Val(v.Value)
-					g.SetRange(v, NewInterval(NewZ(big.NewInt(c)), NewZ(big.NewInt(c))))
+					c := zFromConst(v)
+					g.SetRange(v, NewInterval(c, c))
 				}
 			}
 		}
@@ -336,8 +1384,7 @@ func (g *Graph) Solve() {
 				continue
 			}
 			if (basic.Info() & types.IsInteger) != 0 {
-				v, _ := constant.Int64Val(c.Value)
-				consts = append(consts, NewZ(big.NewInt(v)))
+				consts = append(consts, zFromConst(c))
 			}
 		}
 
@@ -359,7 +1406,7 @@ func (g *Graph) Solve() {
 							g.SetRange(v, StringRange{NewInterval(NewZ(&big.Int{}), PInfinity)})
 						}
 					default:
-						if !g.Range(v).(Interval).IsKnown() {
+						if !enclosing(g.Range(v)).IsKnown() {
 							g.SetRange(v, InfinityFor(v))
 						}
 					}
@@ -428,37 +1475,32 @@ func (g *Graph) Solve() {
 		}
 	}
 
+	// This backstop stays even though some constraints now look at
+	// per-value width/signedness: only division, remainder, left shifts,
+	// and conversions do (see IntQuoConstraint, IntConversionConstraint
+	// and friends), not every constraint that can touch an SCC during
+	// widen/narrow, so a solved range can still end up wider than its
+	// declared type. Such a range is reset here: a negative lower bound
+	// of an unsigned value becomes [0, ∞], and a signed range outside
+	// its type's bounds becomes [-∞, ∞].
 	for v, r := range g.ranges {
 		i, ok := r.(Interval)
 		if !ok {
 			continue
 		}
-		if (v.Type().Underlying().(*types.Basic).Info() & types.IsUnsigned) != 0 {
+		if !isSignedInt(v.Type()) {
 			if i.lower.Sign() == -1 {
 				i = NewInterval(NewZ(&big.Int{}), PInfinity)
 			}
-		}
-		if (v.Type().Underlying().(*types.Basic).Info() & types.IsUnsigned) == 0 {
+		} else {
 			if i.upper == PInfinity {
 				i = NewInterval(NInfinity, PInfinity)
 			}
 			if i.upper != PInfinity {
-				s := &types.StdSizes{
-					// XXX is it okay to assume the largest word size, or do we
-					// need to be platform specific?
-					WordSize: 8,
-					MaxAlign: 1,
-				}
-				bits := (s.Sizeof(v.Type()) * 8) - 1
-				n := big.NewInt(1)
-				n = n.Lsh(n, uint(bits))
-				upper, lower := &big.Int{}, &big.Int{}
-				upper.Sub(n, big.NewInt(1))
-				lower.Neg(n)
-
-				if i.upper.Cmp(NewZ(upper)) == 1 {
+				bits := bitsOf(v.Type())
+				if i.upper.Cmp(maxSigned(bits)) == 1 {
 					i = NewInterval(NInfinity, PInfinity)
-				} else if i.lower.Cmp(NewZ(lower)) == -1 {
+				} else if i.lower.Cmp(minSigned(bits)) == -1 {
 					i = NewInterval(NInfinity, PInfinity)
 				}
 			}
@@ -533,8 +1575,8 @@ func (g *Graph) Range(x ssa.Value) Range {
 		switch typ := x.Type().Underlying().(type) {
 		case *types.Basic:
 			if (typ.Info() & types.IsInteger) != 0 {
-				v, _ := constant.Int64Val(x.Value)
-				return NewInterval(NewZ(big.NewInt(v)), NewZ(big.NewInt(v)))
+				v := zFromConst(x)
+				return NewInterval(v, v)
 			}
 		}
 	}
@@ -558,59 +1600,63 @@ func (g *Graph) Ranges() map[ssa.Value]Range {
 }
 
 func (g *Graph) widen(c Constraint, consts []Z) bool {
-	switch oi := g.Range(c.Y()).(type) {
-	case Interval:
-		ni := c.Eval(g).(Interval)
-		if !ni.IsKnown() {
-			return false
-		}
-		setRange := func(i Interval) {
-			g.SetRange(c.Y(), i)
-		}
-		nlc := NInfinity
-		nuc := PInfinity
-		for _, co := range consts {
-			if co.Cmp(ni.lower) == -1 {
-				nlc = co
-				break
-			}
+	switch g.Range(c.Y()).(type) {
+	case Interval, MultiInterval:
+	default:
+		return false
+	}
+	oi := enclosing(g.Range(c.Y()))
+	ni := enclosing(c.Eval(g))
+	if !ni.IsKnown() {
+		return false
+	}
+	setRange := func(i Interval) {
+		g.SetRange(c.Y(), i)
+	}
+	nlc := NInfinity
+	nuc := PInfinity
+	for _, co := range consts {
+		if co.Cmp(ni.lower) == -1 {
+			nlc = co
+			break
 		}
-		for _, co := range consts {
-			if co.Cmp(ni.upper) == 1 {
-				nuc = co
-				break
-			}
+	}
+	for _, co := range consts {
+		if co.Cmp(ni.upper) == 1 {
+			nuc = co
+			break
 		}
+	}
 
-		if !oi.IsKnown() {
-			setRange(ni)
-			return true
-		}
-		if ni.lower.Cmp(oi.lower) == -1 && ni.upper.Cmp(oi.upper) == 1 {
-			setRange(NewInterval(nlc, nuc))
-			return true
-		}
-		if ni.lower.Cmp(oi.lower) == -1 {
-			setRange(NewInterval(nlc, oi.upper))
-			return true
-		}
-		if ni.upper.Cmp(oi.upper) == 1 {
-			setRange(NewInterval(oi.lower, nuc))
-			return true
-		}
-		return false
-	default:
-		return false
+	if !oi.IsKnown() {
+		setRange(ni)
+		return true
+	}
+	if ni.lower.Cmp(oi.lower) == -1 && ni.upper.Cmp(oi.upper) == 1 {
+		setRange(NewInterval(nlc, nuc))
+		return true
 	}
+	if ni.lower.Cmp(oi.lower) == -1 {
+		setRange(NewInterval(nlc, oi.upper))
+		return true
+	}
+	if ni.upper.Cmp(oi.upper) == 1 {
+		setRange(NewInterval(oi.lower, nuc))
+		return true
+	}
+	return false
 }
 
 func (g *Graph) narrow(c Constraint, consts []Z) bool {
-	if _, ok := g.Range(c.Y()).(Interval); !ok {
+	switch g.Range(c.Y()).(type) {
+	case Interval, MultiInterval:
+	default:
 		return false
 	}
-	oLower := g.Range(c.Y()).(Interval).lower
-	oUpper := g.Range(c.Y()).(Interval).upper
-	newInterval := c.Eval(g).(Interval)
+	old := enclosing(g.Range(c.Y()))
+	oLower := old.lower
+	oUpper := old.upper
+	newInterval := enclosing(c.Eval(g))
 
 	nLower := newInterval.lower
 	nUpper := newInterval.upper
@@ -628,12 +1674,12 @@ func (g *Graph) narrow(c Constraint, consts []Z) bool {
 	}
 
 	if oUpper == PInfinity && nUpper != PInfinity {
-		g.SetRange(c.Y(), NewInterval(g.ranges[c.Y()].(Interval).lower, nUpper))
+		g.SetRange(c.Y(), NewInterval(enclosing(g.Range(c.Y())).lower, nUpper))
 		hasChanged = true
 	} else {
 		smax := Max(oUpper, nUpper)
 		if oUpper != smax {
-			g.SetRange(c.Y(), NewInterval(g.ranges[c.Y()].(Interval).lower, smax))
+			g.SetRange(c.Y(), NewInterval(enclosing(g.Range(c.Y())).lower, smax))
 			hasChanged = true
 		}
 	}